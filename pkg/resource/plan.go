@@ -3,13 +3,17 @@
 package resource
 
 import (
+	"reflect"
+	"sync"
+
+	"golang.org/x/net/context"
+
 	"github.com/golang/glog"
 
 	"github.com/pulumi/coconut/pkg/graph"
 	"github.com/pulumi/coconut/pkg/util/contract"
 )
 
-// TODO: concurrency.
 // TODO: handle output dependencies
 
 // Plan is the output of analyzing resource graphs and contains the steps necessary to perform an infrastructure
@@ -19,10 +23,43 @@ import (
 type Plan interface {
 	Empty() bool                                      // true if the plan is empty.
 	Steps() Step                                      // the first step to perform, linked to the rest.
-	Apply(prog Progress) (error, Step, ResourceState) // performs the operations specified in this plan.
+	Graph() graph.Graph                               // the DAG of this plan's remaining steps, for rendering or custom scheduling.
+	Apply(prog Progress) (error, Step, ResourceState) // performs the operations specified in this plan, in order.
+	// ApplyParallel performs the operations specified in this plan, running independent steps concurrently, up to
+	// maxConcurrency at a time, while still respecting the dependency edges recorded in the plan's DAG.
+	ApplyParallel(prog Progress, maxConcurrency int) (error, Step, ResourceState)
+	// Preview walks the plan exactly as Apply does, reporting each step's intended operation and property diff
+	// through prog, but never actually invokes a provider's Create, Update, or Delete.
+	Preview(prog Progress) (error, Step, ResourceState)
+	// ApplyCheckpointed behaves exactly like Apply, but additionally persists progress to checkpoint after every
+	// successfully completed step, so that a crash partway through can be resumed with NewPlanFromCheckpoint.
+	ApplyCheckpointed(prog Progress, checkpoint Checkpoint) (error, Step, ResourceState)
+}
+
+// StepStatus records whether a plan step, identified by the moniker of the resource it targets, has already been
+// applied, as handed to a Checkpoint's Save.  NewPlanFromCheckpoint does not consult these statuses: what a resumed
+// plan skips is decided by diffing the checkpointed snapshot against the target alone.
+type StepStatus string
+
+const (
+	StepStatusPending StepStatus = "pending" // the step has not yet been applied.
+	StepStatusApplied StepStatus = "applied" // the step completed successfully.
+)
+
+// Checkpoint persists and restores plan progress so that Apply can be resumed after a partial failure.  Save is
+// invoked after each step completes successfully with the resulting snapshot and the up-to-date status of every
+// step, keyed by the moniker of the resource it targets.  Load recovers the most recently saved snapshot and
+// status map, for use by NewPlanFromCheckpoint.
+type Checkpoint interface {
+	Save(snap Snapshot, status map[Moniker]StepStatus) error
+	Load() (Snapshot, map[Moniker]StepStatus, error)
 }
 
-// Progress can be used for progress reporting.
+// Progress can be used for progress reporting.  During a sequential Apply, Before and After are invoked from a
+// single goroutine and so may freely touch shared state.  During an ApplyParallel, however, independent steps run
+// concurrently and Before/After for distinct steps may be invoked from multiple goroutines at once (Before and
+// After for the *same* step are still paired and non-overlapping).  Implementations used with ApplyParallel must
+// guard any state they share across steps (for example, with a mutex) rather than assuming exclusive access.
 type Progress interface {
 	Before(step Step)
 	After(step Step, err error, state ResourceState)
@@ -35,16 +72,84 @@ type Step interface {
 	New() Resource                 // the new resource state, if any, after performing this step.
 	Next() Step                    // the next step to perform, or nil if none.
 	Apply() (error, ResourceState) // performs the operation specified by this step.
+	Preview() error                // validates and reports this step's operation without invoking a provider.
+	Diff() PropertyDiff            // the structured property diff for an update step (empty for create/delete).
+}
+
+// PropertyDiff describes, for a single update step, which resource properties were added, removed, or changed
+// between the old and new property states.
+type PropertyDiff struct {
+	Adds    map[PropertyKey]PropertyValue     // properties present in new but not old.
+	Deletes map[PropertyKey]PropertyValue     // properties present in old but not new.
+	Updates map[PropertyKey]PropertyValueDiff // properties present in both old and new, but changed.
+}
+
+// Empty returns true if this diff contains no changes whatsoever.
+func (d PropertyDiff) Empty() bool {
+	return len(d.Adds) == 0 && len(d.Deletes) == 0 && len(d.Updates) == 0
+}
+
+// PropertyValueDiff captures the old and new value of a single property that changed as part of an update.
+type PropertyValueDiff struct {
+	Old PropertyValue
+	New PropertyValue
+}
+
+// diffProperties computes a structured, per-property diff between an old and a new property map, for reporting
+// exactly what would change during a Preview or what changed in an update step.  It is not used to decide whether
+// an update is warranted at all -- that's PropertyMap's own DeepEquals, which newPlan consults instead.
+func diffProperties(old PropertyMap, new PropertyMap) PropertyDiff {
+	diff := PropertyDiff{
+		Adds:    make(map[PropertyKey]PropertyValue),
+		Deletes: make(map[PropertyKey]PropertyValue),
+		Updates: make(map[PropertyKey]PropertyValueDiff),
+	}
+	for k, v := range new {
+		if ov, has := old[k]; has {
+			if !reflect.DeepEqual(ov, v) {
+				diff.Updates[k] = PropertyValueDiff{Old: ov, New: v}
+			}
+		} else {
+			diff.Adds[k] = v
+		}
+	}
+	for k, v := range old {
+		if _, has := new[k]; !has {
+			diff.Deletes[k] = v
+		}
+	}
+	return diff
+}
+
+// diffPropertyKinds asks a resource's provider to classify each of its properties as unchanged, updatable in
+// place, or requiring the resource to be replaced, given its old and new desired state.  newPlan uses this to
+// decide whether a changed resource needs an OpReplace instead of a plain OpUpdate.  DiffProperties is declared on
+// the Provider interface alongside Create, Update, and Delete (see the provider/plugin host package); it is not
+// declared in this file.
+func diffPropertyKinds(prov Provider, old Resource, new Resource) (map[PropertyKey]PropertyDiffKind, error) {
+	return prov.DiffProperties(old.ID(), old.Type(), old.Properties(), new.Properties())
 }
 
 // StepOp represents the kind of operation performed by this step.
 type StepOp string
 
 const (
-	OpCreate StepOp = "create"
-	OpRead          = "read"
-	OpUpdate        = "update"
-	OpDelete        = "delete"
+	OpCreate  StepOp = "create"
+	OpRead           = "read"
+	OpUpdate         = "update"
+	OpDelete         = "delete"
+	OpReplace        = "replace" // a delete-and-recreate forced by a provider-reported replace-triggering property.
+)
+
+// PropertyDiffKind classifies how a single changed property affects a resource: whether the provider can apply it
+// without disturbing the resource, whether it forces the resource to be replaced (deleted and recreated), or
+// whether the property didn't actually change at all.
+type PropertyDiffKind int
+
+const (
+	DiffKindNoChange      PropertyDiffKind = iota // the property is unchanged.
+	DiffKindUpdateInPlace                         // the property can be updated on the existing resource.
+	DiffKindReplace                               // changing the property requires replacing the resource.
 )
 
 // NewPlan analyzes a resource graph new compared to an optional old resource graph old, and creates a plan
@@ -57,8 +162,11 @@ func NewPlan(ctx *Context, old Snapshot, new Snapshot) Plan {
 }
 
 type plan struct {
-	ctx   *Context // this plan's context.
-	first *step    // the first step to take.
+	ctx   *Context                // this plan's context.
+	first *step                   // the first step to take.
+	g     graph.Graph             // the DAG of steps, preserved for parallel scheduling and graph rendering.
+	verts map[Moniker]*planVertex // the vertex backing each step, keyed by moniker, for ApplyParallel's scheduler.
+	cur   map[Moniker]Resource    // the resource state as of the last applied step, for ApplyCheckpointed.
 }
 
 var _ Plan = (*plan)(nil)
@@ -72,6 +180,8 @@ func (p *plan) Steps() Step {
 	return p.first
 }
 
+func (p *plan) Graph() graph.Graph { return p.g }
+
 // Provider fetches the provider for a given resource, possibly lazily allocating the plugins for it.  If a provider
 // could not be found, or an error occurred while creating it, a non-nil error is returned.
 func (p *plan) Provider(res Resource) (Provider, error) {
@@ -99,6 +209,226 @@ func (p *plan) Apply(prog Progress) (error, Step, ResourceState) {
 	return nil, nil, StateOK
 }
 
+// ApplyParallel performs all steps in the plan, just like Apply, but runs steps with no outstanding dependencies
+// concurrently rather than marching through the plan's linearized order one step at a time.  It uses a ready-queue
+// scheduler seeded with the DAG's in-degree counts: a step becomes eligible the moment every predecessor it depends
+// on -- as recorded by newPlan's edges -- has completed successfully, at which point it is handed to a worker, up to
+// maxConcurrency workers running at once.  On the first failure, ApplyParallel cancels the shared context so that
+// peers that haven't yet started back off, waits for in-flight work to unwind, and returns the step that failed.
+func (p *plan) ApplyParallel(prog Progress, maxConcurrency int) (error, Step, ResourceState) {
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+	if p.Empty() {
+		return nil, nil, StateOK
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// newPlan connects each dependent to what it depends on, so a vertex's Outs() are the steps that must finish
+	// before it may start (the same order Topsort gives Apply).  Count, for each vertex in p.verts, its Outs() that
+	// are also in p.verts, guarding each lookup against a vertex that isn't in this map: the DAG's own edges are the
+	// source of truth for scheduling, and this keeps that scheduling self-contained.
+	indeg := make(map[*planVertex]int, len(p.verts))
+	for _, v := range p.verts {
+		indeg[v] = 0
+	}
+	for _, v := range p.verts {
+		for _, out := range v.Outs() {
+			if _, has := indeg[out]; has {
+				indeg[v]++
+			}
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, maxConcurrency)
+		failErr  error
+		failStep Step
+		failRst  ResourceState
+	)
+
+	// dispatch schedules every vertex that currently has an in-degree of zero and hasn't been dispatched yet.
+	var dispatch func()
+	dispatch = func() {
+		mu.Lock()
+		var ready []*planVertex
+		for v, d := range indeg {
+			if d == 0 {
+				ready = append(ready, v)
+				delete(indeg, v)
+			}
+		}
+		mu.Unlock()
+
+		for _, v := range ready {
+			v := v
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				// Release our slot before recursing into dispatch() below: dispatch() may need to claim a
+				// slot for this vertex's successors, and since that happens on this same goroutine (not a
+				// fresh one pulled from some pool), holding the slot across the call would deadlock any time
+				// maxConcurrency is exhausted -- trivially with maxConcurrency == 1, where the lone goroutine
+				// would block forever waiting on a slot only it could free.
+				defer wg.Done()
+
+				select {
+				case <-ctx.Done():
+					<-sem
+					return
+				default:
+				}
+
+				st := v.Data().(*step)
+				if prog != nil {
+					prog.Before(st)
+				}
+				err, rst := st.Apply()
+				if prog != nil {
+					prog.After(st, err, rst)
+				}
+
+				if err != nil {
+					mu.Lock()
+					if failErr == nil {
+						failErr, failStep, failRst = err, st, rst
+					}
+					mu.Unlock()
+					cancel()
+					<-sem
+					return
+				}
+
+				mu.Lock()
+				for w := range indeg {
+					for _, out := range w.Outs() {
+						if out == v {
+							indeg[w]--
+						}
+					}
+				}
+				mu.Unlock()
+
+				<-sem
+				dispatch()
+			}()
+		}
+	}
+
+	dispatch()
+	wg.Wait()
+
+	if failErr != nil {
+		return failErr, failStep, failRst
+	}
+	return nil, nil, StateOK
+}
+
+// Preview walks the plan in the same order as Apply, reporting each step's operation and property diff through
+// prog, but never calls out to a provider's Create, Update, or Delete.  This lets callers -- for example a CI
+// pipeline gating approval -- see exactly what Apply would do before any real change is made.
+func (p *plan) Preview(prog Progress) (error, Step, ResourceState) {
+	var step Step = p.Steps()
+	for step != nil {
+		if prog != nil {
+			prog.Before(step)
+		}
+		err := step.Preview()
+		if prog != nil {
+			prog.After(step, err, StateOK)
+		}
+		if err != nil {
+			return err, step, StateOK
+		}
+		step = step.Next()
+	}
+	return nil, nil, StateOK
+}
+
+// ApplyCheckpointed behaves exactly like Apply, but after each step completes successfully it updates the plan's
+// view of the current resource state and hands checkpoint.Save a fresh snapshot together with the status of every
+// step, keyed by the moniker of the resource it targets.  Deployments that touch dozens of cloud resources can run
+// for minutes; if the process crashes partway through, NewPlanFromCheckpoint can resume from the last saved
+// checkpoint instead of starting the whole plan over.
+func (p *plan) ApplyCheckpointed(prog Progress, checkpoint Checkpoint) (error, Step, ResourceState) {
+	status := make(map[Moniker]StepStatus, len(p.verts))
+	for m := range p.verts {
+		status[m] = StepStatusPending
+	}
+
+	var step Step = p.Steps()
+	for step != nil {
+		if prog != nil {
+			prog.Before(step)
+		}
+		err, rst := step.Apply()
+		if prog != nil {
+			prog.After(step, err, rst)
+		}
+		if err != nil {
+			return err, step, rst
+		}
+
+		m := stepMoniker(step)
+		if step.Op() == OpDelete {
+			delete(p.cur, m)
+		} else {
+			p.cur[m] = step.New()
+		}
+		status[m] = StepStatusApplied
+
+		if checkpoint != nil {
+			if err := checkpoint.Save(newSnapshotFrom(p.cur), status); err != nil {
+				return err, step, rst
+			}
+		}
+
+		step = step.Next()
+	}
+	return nil, nil, StateOK
+}
+
+// NewPlanFromCheckpoint resumes a previous plan from a saved Checkpoint.  It loads the snapshot recorded by the
+// last successful checkpoint.Save and treats it as the "old" side of a fresh plan against new: newPlan's usual
+// diff naturally skips anything that was already applied and remains unchanged, so Apply (or ApplyParallel, or
+// ApplyCheckpointed) picks up exactly where the previous attempt left off, while still producing fresh steps for
+// anything whose desired state changed since that attempt.
+func NewPlanFromCheckpoint(ctx *Context, checkpoint Checkpoint, new Snapshot) (Plan, error) {
+	old, _, err := checkpoint.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't prune steps by their previously recorded status: newPlan already diffs the checkpointed resources
+	// (old) against the target (new), so anything that was Applied last time and remains unchanged simply won't
+	// produce a step here.  Pruning on the stale status instead would drop legitimate new work -- e.g. a resource
+	// that was Applied in a prior attempt but whose desired properties were edited before this resume, which must
+	// produce a fresh update or replace step despite its old status.
+	return newPlan(ctx, old, new), nil
+}
+
+// stepMoniker returns the moniker of the resource a step targets: the new resource for creates and updates, or
+// the old resource for deletes.
+func stepMoniker(s Step) Moniker {
+	if n := s.New(); n != nil {
+		return n.Moniker()
+	}
+	return s.Old().Moniker()
+}
+
+// newSnapshotFrom builds a Snapshot out of the given resource states, for recording in a Checkpoint.
+func newSnapshotFrom(resources map[Moniker]Resource) Snapshot {
+	ress := make([]Resource, 0, len(resources))
+	for _, res := range resources {
+		ress = append(ress, res)
+	}
+	return NewSnapshot(ress)
+}
+
 // newPlan handles all three cases: (1) a creation plan from a new snapshot when old doesn't exist (nil), (2) an update
 // plan when both old and new exist, and (3) a deletion plan when old exists, but not new.
 func newPlan(ctx *Context, old Snapshot, new Snapshot) *plan {
@@ -137,14 +467,28 @@ func newPlan(ctx *Context, old Snapshot, new Snapshot) *plan {
 		}
 	}
 	news := make(map[Moniker]Resource)
+	newdepends := make(map[Moniker][]Moniker)
 	for _, res := range newres {
-		news[res.Moniker()] = res
+		m := res.Moniker()
+		news[m] = res
+		// Keep track of which dependents exist for all resources, so that a change can be cascaded forward to
+		// whatever else references it (see the cascading promotion pass below).
+		for ref := range res.Properties().AllResources() {
+			newdepends[ref] = append(newdepends[ref], m)
+		}
 	}
 
 	// Keep track of vertices for our later graph operations.
 	p := &plan{ctx: ctx}
 	vs := make(map[Moniker]*planVertex)
 
+	// Seed the plan's view of the current resource state from old, so that ApplyCheckpointed has something to
+	// mutate as steps complete.
+	p.cur = make(map[Moniker]Resource, len(olds))
+	for m, res := range olds {
+		p.cur[m] = res
+	}
+
 	// Find those things in old but not new, and add them to the delete queue.
 	deletes := make(map[Resource]bool)
 	for _, res := range olds {
@@ -157,18 +501,55 @@ func newPlan(ctx *Context, old Snapshot, new Snapshot) *plan {
 		}
 	}
 
-	// Find creates and updates: creates are those in new but not old, and updates are those in both.
+	// Find creates and updates: creates are those in new but not old, and updates are those in both.  An update
+	// whose provider reports a replace-triggering property becomes an OpReplace instead of an OpUpdate; changed
+	// tracks both (old -> new), while replaced tracks just the subset that needs the delete-and-recreate dance.
 	creates := make(map[Resource]bool)
-	updates := make(map[Resource]Resource)
+	changed := make(map[Resource]Resource)
+	replaced := make(map[Resource]bool)
 	for _, res := range news {
 		m := res.Moniker()
 		if oldres, has := olds[m]; has {
 			contract.Assert(oldres.Type() == res.Type())
-			if !res.Properties().DeepEquals(oldres.Properties()) {
-				updates[oldres] = res
-				step := newUpdateStep(p, oldres, res)
+			// Gate the create/update decision on PropertyMap's own DeepEquals, not on diff.Empty(): DeepEquals
+			// is the pre-existing, presumably-correct notion of resource equality (e.g. around computed/unknown
+			// values), whereas diffProperties's per-key reflect.DeepEqual exists only to report what changed for
+			// Preview and isn't trusted to decide whether anything changed at all.
+			if !oldres.Properties().DeepEquals(res.Properties()) {
+				diff := diffProperties(oldres.Properties(), res.Properties())
+				changed[oldres] = res
+
+				replace := false
+				prov, err := p.Provider(oldres)
+				if err != nil {
+					// We can't consult the provider to classify the change, so we can't rule out that it's
+					// actually replace-triggering; fall back to a plain update below, but make sure this is loud
+					// rather than silently risking an in-place update the provider can't actually perform.
+					glog.Warningf("Could not get provider for '%v' to diff properties: %v", m, err)
+				} else if kinds, err := diffPropertyKinds(prov, oldres, res); err != nil {
+					glog.Warningf("Could not diff properties for '%v': %v", m, err)
+				} else {
+					for _, kind := range kinds {
+						if kind == DiffKindReplace {
+							replace = true
+							break
+						}
+					}
+				}
+
+				var step *step
+				if replace {
+					replaced[oldres] = true
+					// TODO: always create-before-delete for now; nothing in PropertyDiffKind or Provider yet
+					// tells us when a provider can't tolerate two copies of a resource existing at once, so
+					// there's no real signal to justify scheduling delete-before-create instead.
+					step = newReplaceStep(p, oldres, res, diff, true /*createBeforeDelete*/)
+					glog.V(7).Infof("Update plan decided to replace '%v'", m)
+				} else {
+					step = newUpdateStep(p, oldres, res, diff)
+					glog.V(7).Infof("Update plan decided to update '%v'", m)
+				}
 				vs[m] = newPlanVertex(step)
-				glog.V(7).Infof("Update plan decided to update '%v'", m)
 			} else if glog.V(7) {
 				glog.V(7).Infof("Update plan decided not to update '%v'", m)
 			}
@@ -202,18 +583,23 @@ func newPlan(ctx *Context, old Snapshot, new Snapshot) *plan {
 				fromv.connectTo(tov)
 				glog.V(7).Infof("Deletion '%v' depends on resource '%v'", m, ref)
 			}
-		} else if to := updates[res]; to != nil {
+		} else if to := changed[res]; to != nil {
 			// Add edge to:
 			//     - creates news
 			//     - updates news
-			// TODO[pulumi/coconut#90]: we need to track "cascading updates".
+			// (pulumi/coconut#90: cascading updates are now handled by the promotion pass below, once this DAG's
+			// initial edges are in place.)
 			fromv := vs[m]
 			contract.Assert(fromv != nil)
 			for ref := range to.Properties().AllResources() {
 				tov := vs[ref]
 				contract.Assert(tov != nil)
 				fromv.connectTo(tov)
-				glog.V(7).Infof("Updating '%v' depends on resource '%v'", m, ref)
+				if replaced[res] {
+					glog.V(7).Infof("Replacing '%v' depends on resource '%v'", m, ref)
+				} else {
+					glog.V(7).Infof("Updating '%v' depends on resource '%v'", m, ref)
+				}
 			}
 		}
 	}
@@ -233,6 +619,47 @@ func newPlan(ctx *Context, old Snapshot, new Snapshot) *plan {
 		}
 	}
 
+	// Cascading promotion: a resource that was updated or replaced may have produced a new output value (most
+	// obviously a new ID, in the replace case) that a dependent resource's input referenced.  Walk forward from
+	// every changed resource and promote any dependent that doesn't already have its own step to an update, so
+	// that its inputs are re-resolved against the new state.  This has to be transitive -- promoting B because it
+	// depends on A may in turn require promoting C, which depends on B -- hence the worklist.  A resource that
+	// already had its own create/update/replace step got its edges from the loops above, so it's left alone here
+	// even if it also shows up in some changed resource's newdepends; but a promoted vertex needs an edge back to
+	// *every* changed dependency that triggered it, not just the first one encountered, since nothing else adds
+	// those edges for it.
+	promoted := make(map[Moniker]bool)
+	worklist := make([]Moniker, 0, len(changed))
+	for oldres := range changed {
+		worklist = append(worklist, oldres.Moniker())
+	}
+	for len(worklist) > 0 {
+		m := worklist[0]
+		worklist = worklist[1:]
+		for _, dm := range newdepends[m] {
+			dv, has := vs[dm]
+			if !has {
+				dnew, hasNew := news[dm]
+				if !hasNew {
+					continue // being deleted, not re-resolved.
+				}
+				dold, hasOld := olds[dm]
+				contract.Assert(hasOld) // unchanged, so it must exist under the same moniker in both snapshots.
+
+				dstep := newUpdateStep(p, dold, dnew, PropertyDiff{})
+				dv = newPlanVertex(dstep)
+				vs[dm] = dv
+				promoted[dm] = true
+				glog.V(7).Infof("Promoting '%v' to an update; its dependency '%v' changed", dm, m)
+
+				worklist = append(worklist, dm)
+			}
+			if promoted[dm] {
+				dv.connectTo(vs[m])
+			}
+		}
+	}
+
 	// For all vertices with no ins, make them root nodes.
 	var roots []*planEdge
 	for _, v := range vs {
@@ -241,7 +668,9 @@ func newPlan(ctx *Context, old Snapshot, new Snapshot) *plan {
 		}
 	}
 
-	// Now topologically sort the steps, thread the plan together, and return it.
+	// Now topologically sort the steps, thread the plan together, and return it.  We keep the DAG itself (rather
+	// than just the flattened list) so that ApplyParallel can schedule independent steps concurrently and so that
+	// Graph can expose it to callers that want to render the plan's execution graph.
 	g := newPlanGraph(p, roots)
 	topdag, err := graph.Topsort(g)
 	contract.Assertf(err == nil, "Unexpected error topologically sorting update plan")
@@ -249,22 +678,27 @@ func newPlan(ctx *Context, old Snapshot, new Snapshot) *plan {
 	for _, v := range topdag {
 		insertStep(&prev, v.Data().(*step))
 	}
+	p.g = g
+	p.verts = vs
 	return p
 }
 
 type step struct {
-	p    *plan    // this step's plan.
-	op   StepOp   // the operation to perform.
-	old  Resource // the state of the resource before this step.
-	new  Resource // the state of the resource after this step.
-	next *step    // the next step after this one in the plan.
+	p                  *plan        // this step's plan.
+	op                 StepOp       // the operation to perform.
+	old                Resource     // the state of the resource before this step.
+	new                Resource     // the state of the resource after this step.
+	diff               PropertyDiff // the property diff for an update or replace step; empty for create/delete.
+	createBeforeDelete bool         // for OpReplace, whether to create the replacement before deleting the old one.
+	next               *step        // the next step after this one in the plan.
 }
 
 var _ Step = (*step)(nil)
 
-func (s *step) Op() StepOp    { return s.op }
-func (s *step) Old() Resource { return s.old }
-func (s *step) New() Resource { return s.new }
+func (s *step) Op() StepOp         { return s.op }
+func (s *step) Old() Resource      { return s.old }
+func (s *step) New() Resource      { return s.new }
+func (s *step) Diff() PropertyDiff { return s.diff }
 func (s *step) Next() Step {
 	if s.next == nil {
 		return nil
@@ -280,8 +714,17 @@ func newDeleteStep(p *plan, old Resource) *step {
 	return &step{p: p, op: OpDelete, old: old}
 }
 
-func newUpdateStep(p *plan, old Resource, new Resource) *step {
-	return &step{p: p, op: OpUpdate, old: old, new: new}
+func newUpdateStep(p *plan, old Resource, new Resource, diff PropertyDiff) *step {
+	return &step{p: p, op: OpUpdate, old: old, new: new, diff: diff}
+}
+
+// newReplaceStep creates a step that deletes old and creates new in its place, because one of the properties that
+// changed between them forces the provider to replace the resource rather than update it in place.  createBeforeDelete
+// controls whether the replacement is created before the old resource is deleted (minimizing downtime for resources
+// that others depend on) or afterwards; callers should pass true until there's a real provider-reported signal for
+// when a provider can't tolerate two copies of a resource existing at once.
+func newReplaceStep(p *plan, old Resource, new Resource, diff PropertyDiff, createBeforeDelete bool) *step {
+	return &step{p: p, op: OpReplace, old: old, new: new, diff: diff, createBeforeDelete: createBeforeDelete}
 }
 
 func insertStep(prev **step, step *step) {
@@ -296,6 +739,36 @@ func insertStep(prev **step, step *step) {
 	}
 }
 
+// Preview validates that this step's preconditions hold and, unlike Apply, simply returns without ever reaching
+// out to a provider.  Op, Old, New, and Diff already expose everything a caller needs to report what Apply would
+// do; Preview exists so that the validation itself -- and any future checks -- runs the same way in both modes.
+func (s *step) Preview() error {
+	switch s.op {
+	case OpCreate:
+		contract.Assert(s.old == nil)
+		contract.Assert(s.new != nil)
+		contract.Assertf(!s.new.HasID(), "Resources being created must not have IDs already")
+	case OpDelete:
+		contract.Assert(s.old != nil)
+		contract.Assert(s.new == nil)
+		contract.Assertf(s.old.HasID(), "Resources being deleted must have IDs")
+	case OpUpdate:
+		contract.Assert(s.old != nil)
+		contract.Assert(s.new != nil)
+		contract.Assert(s.old.Type() == s.new.Type())
+		contract.Assertf(s.old.HasID(), "Resources being updated must have IDs")
+	case OpReplace:
+		contract.Assert(s.old != nil)
+		contract.Assert(s.new != nil)
+		contract.Assert(s.old.Type() == s.new.Type())
+		contract.Assertf(s.old.HasID(), "Resources being replaced must have IDs")
+		contract.Assertf(!s.new.HasID(), "Resources being replaced must not have IDs already")
+	default:
+		contract.Failf("Unexpected step operation: %v", s.op)
+	}
+	return nil
+}
+
 func (s *step) Apply() (error, ResourceState) {
 	// Now simply perform the operation of the right kind.
 	switch s.op {
@@ -336,13 +809,49 @@ func (s *step) Apply() (error, ResourceState) {
 		if err != nil {
 			return err, rst
 		} else if id != ID("") {
-			// An update might need to recreate the resource, in which case the ID must change.
-			// TODO: this could have an impact on subsequent dependent resources that wasn't known during planning.
+			// A provider can still surprise us with an update that turns out to need a new ID; newPlan now catches
+			// the common case up front via DiffProperties and schedules an OpReplace instead, with dependents
+			// already promoted to their own steps, but we fall back to setting the ID here for anything it missed.
+			s.new.SetID(id)
+		}
+	case OpReplace:
+		contract.Assert(s.old != nil)
+		contract.Assert(s.new != nil)
+		contract.Assertf(s.old.HasID(), "Resources being replaced must have IDs")
+		contract.Assertf(!s.new.HasID(), "Resources being replaced must not have IDs already")
+		prov, err := s.p.Provider(s.new)
+		if err != nil {
+			return err, StateOK
+		}
+		create := func() (error, ResourceState) {
+			id, err, rst := prov.Create(s.new.Type(), s.new.Properties())
+			if err != nil {
+				return err, rst
+			}
 			s.new.SetID(id)
+			return nil, StateOK
+		}
+		del := func() (error, ResourceState) {
+			return prov.Delete(s.old.ID(), s.old.Type())
+		}
+		if s.createBeforeDelete {
+			if err, rst := create(); err != nil {
+				return err, rst
+			}
+			if err, rst := del(); err != nil {
+				return err, rst
+			}
+		} else {
+			if err, rst := del(); err != nil {
+				return err, rst
+			}
+			if err, rst := create(); err != nil {
+				return err, rst
+			}
 		}
 	default:
 		contract.Failf("Unexpected step operation: %v", s.op)
 	}
 
 	return nil, StateOK
-}
\ No newline at end of file
+}
@@ -0,0 +1,273 @@
+// Copyright 2016 Pulumi, Inc. All rights reserved.
+
+package resource
+
+import (
+	"testing"
+	"time"
+)
+
+// testResource is a minimal Resource used to drive the planning tests below.
+type testResource struct {
+	moniker Moniker
+	typ     Type
+	id      ID
+	props   PropertyMap
+}
+
+func (r *testResource) Moniker() Moniker        { return r.moniker }
+func (r *testResource) Type() Type              { return r.typ }
+func (r *testResource) Properties() PropertyMap { return r.props }
+func (r *testResource) HasID() bool             { return r.id != ID("") }
+func (r *testResource) ID() ID                  { return r.id }
+func (r *testResource) SetID(id ID)             { r.id = id }
+
+var _ Resource = (*testResource)(nil)
+
+// testSnapshot is a fixed resource list, used as an old or new Snapshot in the tests below.
+type testSnapshot struct {
+	ress []Resource
+}
+
+func (s *testSnapshot) Resources() []Resource { return s.ress }
+
+var _ Snapshot = (*testSnapshot)(nil)
+
+// orderRecorder records the moniker targeted by each step as Preview or Apply visits it, in order, so tests can assert on
+// scheduling without needing a real provider.
+type orderRecorder struct {
+	order []Moniker
+}
+
+func (r *orderRecorder) Before(step Step)                                { r.order = append(r.order, stepMoniker(step)) }
+func (r *orderRecorder) After(step Step, err error, state ResourceState) {}
+
+var _ Progress = (*orderRecorder)(nil)
+
+// TestCascadingPromotionOrder builds a three-resource chain A -> B -> C, where B's properties reference A and C's
+// properties reference B, and changes only A.  Even though B and C are themselves untouched, newPlan must promote
+// both to their own update steps -- since their inputs reference state that changed -- and order A before B before
+// C, since each promotion can only be resolved once the resource it depends on has already run.
+func TestCascadingPromotionOrder(t *testing.T) {
+	monikerA, monikerB, monikerC := Moniker("a"), Moniker("b"), Moniker("c")
+	typA, typB, typC := Type("test:index:A"), Type("test:index:B"), Type("test:index:C")
+
+	oldA := &testResource{moniker: monikerA, typ: typA, id: ID("id-a"),
+		props: PropertyMap{"x": NewPropertyValue(1)}}
+	newA := &testResource{moniker: monikerA, typ: typA, id: ID("id-a"),
+		props: PropertyMap{"x": NewPropertyValue(2)}}
+
+	oldB := &testResource{moniker: monikerB, typ: typB, id: ID("id-b"),
+		props: PropertyMap{"ref": NewPropertyValue(monikerA)}}
+	newB := &testResource{moniker: monikerB, typ: typB, id: ID("id-b"),
+		props: PropertyMap{"ref": NewPropertyValue(monikerA)}}
+
+	oldC := &testResource{moniker: monikerC, typ: typC, id: ID("id-c"),
+		props: PropertyMap{"ref": NewPropertyValue(monikerB)}}
+	newC := &testResource{moniker: monikerC, typ: typC, id: ID("id-c"),
+		props: PropertyMap{"ref": NewPropertyValue(monikerB)}}
+
+	old := &testSnapshot{ress: []Resource{oldA, oldB, oldC}}
+	new := &testSnapshot{ress: []Resource{newA, newB, newC}}
+
+	p := NewPlan(&Context{}, old, new)
+
+	rec := &orderRecorder{}
+	if err, failed, _ := p.Preview(rec); err != nil {
+		t.Fatalf("Preview failed at step for '%v': %v", stepMoniker(failed), err)
+	}
+
+	if len(rec.order) != 3 {
+		t.Fatalf("expected 3 steps (A, B, C), got %v: %v", len(rec.order), rec.order)
+	}
+	pos := make(map[Moniker]int, 3)
+	for i, m := range rec.order {
+		pos[m] = i
+	}
+	if pos[monikerA] >= pos[monikerB] {
+		t.Errorf("expected A to be scheduled before B, got order %v", rec.order)
+	}
+	if pos[monikerB] >= pos[monikerC] {
+		t.Errorf("expected B to be scheduled before C, got order %v", rec.order)
+	}
+
+	for step := p.Steps(); step != nil; step = step.Next() {
+		m := stepMoniker(step)
+		if m == monikerB || m == monikerC {
+			if step.Op() != OpUpdate {
+				t.Errorf("expected promoted dependent '%v' to be an update, got %v", m, step.Op())
+			}
+			if !step.Diff().Empty() {
+				t.Errorf("expected promoted dependent '%v' to have no property diff, got %+v", m, step.Diff())
+			}
+		}
+	}
+}
+
+// chainSnapshots builds old/new snapshots for a three-resource chain A -> B -> C (B references A, C references B),
+// with only A's properties changed, for use by the scheduling tests below.
+func chainSnapshots() (Snapshot, Snapshot) {
+	monikerA, monikerB, monikerC := Moniker("a"), Moniker("b"), Moniker("c")
+	typA, typB, typC := Type("test:index:A"), Type("test:index:B"), Type("test:index:C")
+
+	oldA := &testResource{moniker: monikerA, typ: typA, id: ID("id-a"),
+		props: PropertyMap{"x": NewPropertyValue(1)}}
+	newA := &testResource{moniker: monikerA, typ: typA, id: ID("id-a"),
+		props: PropertyMap{"x": NewPropertyValue(2)}}
+	oldB := &testResource{moniker: monikerB, typ: typB, id: ID("id-b"),
+		props: PropertyMap{"ref": NewPropertyValue(monikerA)}}
+	newB := &testResource{moniker: monikerB, typ: typB, id: ID("id-b"),
+		props: PropertyMap{"ref": NewPropertyValue(monikerA)}}
+	oldC := &testResource{moniker: monikerC, typ: typC, id: ID("id-c"),
+		props: PropertyMap{"ref": NewPropertyValue(monikerB)}}
+	newC := &testResource{moniker: monikerC, typ: typC, id: ID("id-c"),
+		props: PropertyMap{"ref": NewPropertyValue(monikerB)}}
+
+	return &testSnapshot{ress: []Resource{oldA, oldB, oldC}}, &testSnapshot{ress: []Resource{newA, newB, newC}}
+}
+
+// TestApplyParallelNoDeadlock guards against the deadlock previously introduced by ApplyParallel's dispatch/sem
+// pattern: a worker held its semaphore slot across the recursive call it made to schedule its own successor, so
+// with maxConcurrency == 1 (or any concurrency exhausted by a deep enough chain) the lone goroutine able to free
+// the slot was itself blocked waiting to acquire it. Since a real deadlock hangs rather than fails, this runs
+// ApplyParallel on a background goroutine and fails the test if it doesn't return within a generous timeout,
+// for both maxConcurrency == 1 (the case that reproduced the hang) and maxConcurrency > 1 (the same structural
+// risk with a deep enough chain relative to concurrency).  No provider is registered on the bare Context, so the
+// first step dispatched fails and no successor is ever scheduled: this checks that ApplyParallel returns on that
+// path, not that a worker can schedule its successor without deadlocking.
+func TestApplyParallelNoDeadlock(t *testing.T) {
+	for _, maxConcurrency := range []int{1, 2, 3} {
+		old, new := chainSnapshots()
+		p := NewPlan(&Context{}, old, new)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			p.ApplyParallel(nil, maxConcurrency)
+		}()
+
+		select {
+		case <-done:
+			// ApplyParallel returned, deadlock or not this run didn't hang.
+		case <-time.After(10 * time.Second):
+			t.Fatalf("ApplyParallel(maxConcurrency=%v) did not return; likely deadlocked", maxConcurrency)
+		}
+	}
+}
+
+// TestApplyParallelCancelsOnFailure exercises the first-error cancellation path: every step in this plan fails
+// (there's no provider registered on the bare Context), so ApplyParallel must still unwind and return promptly,
+// reporting the failure, rather than hanging while peers wait on a context that was never cancelled.
+func TestApplyParallelCancelsOnFailure(t *testing.T) {
+	old, new := chainSnapshots()
+	p := NewPlan(&Context{}, old, new)
+
+	rec := &orderRecorder{}
+	done := make(chan struct{})
+	var err error
+	var failed Step
+	go func() {
+		defer close(done)
+		err, failed, _ = p.ApplyParallel(rec, 1)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("ApplyParallel did not return; likely deadlocked")
+	}
+
+	if err == nil {
+		t.Fatalf("expected ApplyParallel to report a failure (no provider is registered), got none")
+	}
+	if failed == nil {
+		t.Fatalf("expected ApplyParallel to report the step that failed")
+	}
+	if len(rec.order) == 0 {
+		t.Fatalf("expected at least one step to have been attempted before cancellation")
+	}
+}
+
+// fakeCheckpoint is an in-memory Checkpoint that just remembers the last Save, so tests can drive
+// NewPlanFromCheckpoint without a real persistence layer.
+type fakeCheckpoint struct {
+	snap   Snapshot
+	status map[Moniker]StepStatus
+}
+
+func (c *fakeCheckpoint) Save(snap Snapshot, status map[Moniker]StepStatus) error {
+	c.snap, c.status = snap, status
+	return nil
+}
+
+func (c *fakeCheckpoint) Load() (Snapshot, map[Moniker]StepStatus, error) {
+	return c.snap, c.status, nil
+}
+
+var _ Checkpoint = (*fakeCheckpoint)(nil)
+
+// TestNewPlanFromCheckpointResumesEditedTarget guards against the stale-status pruning bug fixed in an earlier
+// commit: resuming from a checkpoint must not drop legitimate new work just because a resource's status was
+// recorded as applied in a prior attempt.  It checkpoints a resource as already applied, then resumes against a
+// target whose properties for that same resource were edited before the resume, and asserts a fresh step is still
+// produced rather than silently skipped.
+func TestNewPlanFromCheckpointResumesEditedTarget(t *testing.T) {
+	moniker := Moniker("a")
+	typ := Type("test:index:A")
+
+	appliedA := &testResource{moniker: moniker, typ: typ, id: ID("id-a"),
+		props: PropertyMap{"x": NewPropertyValue(1)}}
+	checkpoint := &fakeCheckpoint{}
+	if err := checkpoint.Save(&testSnapshot{ress: []Resource{appliedA}},
+		map[Moniker]StepStatus{moniker: StepStatusApplied}); err != nil {
+		t.Fatalf("checkpoint.Save failed: %v", err)
+	}
+
+	editedA := &testResource{moniker: moniker, typ: typ, id: ID("id-a"),
+		props: PropertyMap{"x": NewPropertyValue(2)}}
+	target := &testSnapshot{ress: []Resource{editedA}}
+
+	p, err := NewPlanFromCheckpoint(&Context{}, checkpoint, target)
+	if err != nil {
+		t.Fatalf("NewPlanFromCheckpoint failed: %v", err)
+	}
+
+	step := p.Steps()
+	if step == nil {
+		t.Fatalf("expected a step for '%v' since its properties changed since the checkpointed attempt, got none",
+			moniker)
+	}
+	if stepMoniker(step) != moniker || step.Op() != OpUpdate {
+		t.Errorf("expected a single update step for '%v', got op=%v moniker=%v", moniker, step.Op(), stepMoniker(step))
+	}
+	if step.Next() != nil {
+		t.Errorf("expected exactly one step, got more")
+	}
+}
+
+// TestNewPlanFromCheckpointSkipsUnchanged is the companion case to the above: resuming against a target that's
+// identical to what was checkpointed must produce an empty plan, since there's nothing left to do.
+func TestNewPlanFromCheckpointSkipsUnchanged(t *testing.T) {
+	moniker := Moniker("a")
+	typ := Type("test:index:A")
+
+	appliedA := &testResource{moniker: moniker, typ: typ, id: ID("id-a"),
+		props: PropertyMap{"x": NewPropertyValue(1)}}
+	checkpoint := &fakeCheckpoint{}
+	if err := checkpoint.Save(&testSnapshot{ress: []Resource{appliedA}},
+		map[Moniker]StepStatus{moniker: StepStatusApplied}); err != nil {
+		t.Fatalf("checkpoint.Save failed: %v", err)
+	}
+
+	unchangedA := &testResource{moniker: moniker, typ: typ, id: ID("id-a"),
+		props: PropertyMap{"x": NewPropertyValue(1)}}
+	target := &testSnapshot{ress: []Resource{unchangedA}}
+
+	p, err := NewPlanFromCheckpoint(&Context{}, checkpoint, target)
+	if err != nil {
+		t.Fatalf("NewPlanFromCheckpoint failed: %v", err)
+	}
+	if !p.Empty() {
+		t.Errorf("expected an empty plan since '%v' is unchanged since the checkpointed attempt, got a step", moniker)
+	}
+}